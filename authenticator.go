@@ -0,0 +1,269 @@
+package nanoauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator verifies a single request and, on success, returns the
+// identity it authenticated (a username, token, certificate CN, etc). Auth
+// tries each configured Authenticator in order and uses the first one that
+// succeeds.
+type Authenticator interface {
+	Authenticate(r *http.Request) (identity string, err error)
+}
+
+// ErrAuthenticationFailed is returned by the built-in Authenticators when a
+// request does not carry valid credentials for that scheme.
+var ErrAuthenticationFailed = errors.New("nanoauth: authentication failed")
+
+// identityContextKey is the context key ServeHTTP stashes the identity
+// resolved by self.authenticate under.
+type identityContextKey struct{}
+
+// Identity returns the identity resolved by an Authenticator for req's
+// context, if any.
+func Identity(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(string)
+	return identity, ok
+}
+
+// authenticate tries self.Authenticators in order, returning the identity of
+// the first one to succeed.
+func (self Auth) authenticate(req *http.Request) (string, bool) {
+	for _, a := range self.Authenticators {
+		identity, err := a.Authenticate(req)
+		if err == nil {
+			return identity, true
+		}
+	}
+
+	return "", false
+}
+
+// HeaderTokenAuthenticator authenticates with a shared token sent in a
+// custom header, or as a form value of the same name if the header is
+// absent - the scheme Auth has always supported via Token/Tokens/Header.
+type HeaderTokenAuthenticator struct {
+	Header      string
+	Tokens      []string
+	TokenSource TokenSource
+}
+
+// Authenticate implements Authenticator.
+func (a HeaderTokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := r.Header.Get(a.Header)
+	if token == "" {
+		token = r.FormValue(a.Header)
+	}
+	if token == "" {
+		return "", ErrAuthenticationFailed
+	}
+
+	candidates := a.Tokens
+	if a.TokenSource != nil {
+		candidates = append(candidates, a.TokenSource.Tokens()...)
+	}
+
+	for _, candidate := range candidates {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			return token, nil
+		}
+	}
+
+	return "", ErrAuthenticationFailed
+}
+
+// BearerAuthenticator authenticates RFC 6750 bearer tokens sent as
+// "Authorization: Bearer <token>".
+type BearerAuthenticator struct {
+	Tokens      []string
+	TokenSource TokenSource
+}
+
+// Authenticate implements Authenticator.
+func (a BearerAuthenticator) Authenticate(r *http.Request) (string, error) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", ErrAuthenticationFailed
+	}
+	token := strings.TrimPrefix(header, prefix)
+
+	candidates := a.Tokens
+	if a.TokenSource != nil {
+		candidates = append(candidates, a.TokenSource.Tokens()...)
+	}
+
+	for _, candidate := range candidates {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			return token, nil
+		}
+	}
+
+	return "", ErrAuthenticationFailed
+}
+
+// BasicAuthenticator authenticates HTTP Basic credentials against a fixed
+// set of usernames/passwords.
+type BasicAuthenticator struct {
+	Credentials map[string]string // username -> password
+}
+
+// Authenticate implements Authenticator.
+func (a BasicAuthenticator) Authenticate(r *http.Request) (string, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", ErrAuthenticationFailed
+	}
+
+	want, exists := a.Credentials[user]
+	if !exists || subtle.ConstantTimeCompare([]byte(pass), []byte(want)) != 1 {
+		return "", ErrAuthenticationFailed
+	}
+
+	return user, nil
+}
+
+// ClientCertAuthenticator authenticates clients by their mTLS client
+// certificate, verifying it against Roots.
+type ClientCertAuthenticator struct {
+	Roots *x509.CertPool
+}
+
+// Authenticate implements Authenticator.
+func (a ClientCertAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if a.Roots == nil {
+		// x509.VerifyOptions treats a nil Roots as "use the system roots",
+		// which would let a cert chained to any public CA authenticate as a
+		// client. Fail closed instead of silently widening trust.
+		return "", ErrAuthenticationFailed
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", ErrAuthenticationFailed
+	}
+
+	leaf := r.TLS.PeerCertificates[0]
+	opts := x509.VerifyOptions{
+		Roots:         a.Roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return "", ErrAuthenticationFailed
+	}
+
+	return leaf.Subject.CommonName, nil
+}
+
+// HMACAuthenticator authenticates requests signed by the client with a
+// shared secret: the client computes
+// HMAC-SHA256(method + "\n" + path + "\n" + date + "\n" + hex(sha256(body)))
+// and sends the date, a single-use nonce, and the hex-encoded signature in
+// the X-Nanoauth-Date, X-Nanoauth-Nonce, and X-Nanoauth-Signature headers.
+// Requests outside MaxSkew of the server's clock, or replaying a nonce seen
+// within that window, are rejected.
+type HMACAuthenticator struct {
+	Secret  []byte
+	MaxSkew time.Duration // defaults to 5 minutes if zero
+
+	once   sync.Once
+	nonces *nonceCache
+}
+
+// Authenticate implements Authenticator.
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (string, error) {
+	a.once.Do(func() {
+		a.nonces = newNonceCache()
+	})
+
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = 5 * time.Minute
+	}
+
+	date := r.Header.Get("X-Nanoauth-Date")
+	nonce := r.Header.Get("X-Nanoauth-Nonce")
+	sig := r.Header.Get("X-Nanoauth-Signature")
+	if date == "" || nonce == "" || sig == "" {
+		return "", ErrAuthenticationFailed
+	}
+
+	signedAt, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", ErrAuthenticationFailed
+	}
+	if skew := time.Since(signedAt); skew > maxSkew || skew < -maxSkew {
+		return "", ErrAuthenticationFailed
+	}
+
+	if !a.nonces.reserve(nonce, maxSkew) {
+		return "", ErrAuthenticationFailed
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", ErrAuthenticationFailed
+	}
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + date + "\n" + hex.EncodeToString(bodyHash[:])))
+	expected := mac.Sum(nil)
+
+	given, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(given, expected) {
+		return "", ErrAuthenticationFailed
+	}
+
+	return r.Method + " " + r.URL.Path, nil
+}
+
+// nonceCache remembers nonces seen within the last window so a captured,
+// signed request can't be replayed.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// reserve records nonce as used and reports whether it was unused. Entries
+// older than window are evicted as reserve runs.
+func (c *nonceCache) reserve(nonce string, window time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for n, seenAt := range c.seen {
+		if now.Sub(seenAt) > window {
+			delete(c.seen, n)
+		}
+	}
+
+	if _, replay := c.seen[nonce]; replay {
+		return false
+	}
+
+	c.seen[nonce] = now
+	return true
+}
@@ -0,0 +1,81 @@
+package nanoauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, secret []byte, method, path, body, date, nonce string) *http.Request {
+	t.Helper()
+
+	bodyHash := sha256.Sum256([]byte(body))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + date + "\n" + hex.EncodeToString(bodyHash[:])))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Nanoauth-Date", date)
+	req.Header.Set("X-Nanoauth-Nonce", nonce)
+	req.Header.Set("X-Nanoauth-Signature", sig)
+
+	return req
+}
+
+func TestHMACAuthenticatorValidRequest(t *testing.T) {
+	secret := []byte("shared-secret")
+	a := &HMACAuthenticator{Secret: secret}
+
+	date := time.Now().UTC().Format(time.RFC3339)
+	req := signedRequest(t, secret, http.MethodPost, "/widgets", `{"ok":true}`, date, "nonce-1")
+
+	if _, err := a.Authenticate(req); err != nil {
+		t.Fatalf("expected a validly signed request to authenticate, got %v", err)
+	}
+}
+
+func TestHMACAuthenticatorRejectsReplayedNonce(t *testing.T) {
+	secret := []byte("shared-secret")
+	a := &HMACAuthenticator{Secret: secret}
+
+	date := time.Now().UTC().Format(time.RFC3339)
+	body := `{"ok":true}`
+
+	first := signedRequest(t, secret, http.MethodPost, "/widgets", body, date, "nonce-replay")
+	if _, err := a.Authenticate(first); err != nil {
+		t.Fatalf("first use of the nonce should authenticate, got %v", err)
+	}
+
+	second := signedRequest(t, secret, http.MethodPost, "/widgets", body, date, "nonce-replay")
+	if _, err := a.Authenticate(second); err != ErrAuthenticationFailed {
+		t.Fatalf("replayed nonce should be rejected, got %v", err)
+	}
+}
+
+func TestHMACAuthenticatorRejectsStaleDate(t *testing.T) {
+	secret := []byte("shared-secret")
+	a := &HMACAuthenticator{Secret: secret, MaxSkew: time.Minute}
+
+	date := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	req := signedRequest(t, secret, http.MethodPost, "/widgets", "", date, "nonce-stale")
+
+	if _, err := a.Authenticate(req); err != ErrAuthenticationFailed {
+		t.Fatalf("a date outside MaxSkew should be rejected, got %v", err)
+	}
+}
+
+func TestHMACAuthenticatorRejectsBadSignature(t *testing.T) {
+	a := &HMACAuthenticator{Secret: []byte("shared-secret")}
+
+	date := time.Now().UTC().Format(time.RFC3339)
+	req := signedRequest(t, []byte("wrong-secret"), http.MethodPost, "/widgets", "", date, "nonce-bad-sig")
+
+	if _, err := a.Authenticate(req); err != ErrAuthenticationFailed {
+		t.Fatalf("a signature from the wrong secret should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package nanoauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Generate creates a self-signed certificate/key pair for the given host (an
+// IP address or DNS name) and returns it as a *tls.Certificate ready to be
+// assigned to Auth.Certificate. It is intended as a convenient default for
+// development and for callers that don't need a certificate signed by a
+// trusted CA.
+func Generate(host string) (*tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Nanobox"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(10 * 365 * 24 * time.Hour),
+
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// LoadCertificateFromFiles loads a certificate/key pair from PEM-encoded
+// files on disk.
+func LoadCertificateFromFiles(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// LoadCertificateFromPEM loads a certificate/key pair from in-memory
+// PEM-encoded bytes, for callers that already hold the chain (e.g. fetched
+// from a secrets manager) and don't want to round-trip it through disk.
+func LoadCertificateFromPEM(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// WatchCertificateFiles loads the certificate/key pair at certPath/keyPath
+// and installs a GetCertificate callback that re-reads them from disk
+// whenever their mtimes change, checked every interval. This lets a
+// long-running server pick up a renewed certificate (e.g. from cert-manager
+// or certbot) without a restart. Errors encountered while reloading are
+// ignored and the previously loaded certificate keeps serving. The
+// background reload goroutine it starts is stopped by self.Close/Shutdown.
+func (self *Auth) WatchCertificateFiles(certPath, keyPath string, interval time.Duration) error {
+	cert, err := LoadCertificateFromFiles(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	certInfo, err := os.Stat(certPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(keyPath)
+	if err != nil {
+		return err
+	}
+
+	w := &watchedCertificate{
+		certPath:    certPath,
+		keyPath:     keyPath,
+		certModTime: certInfo.ModTime(),
+		keyModTime:  keyInfo.ModTime(),
+		stop:        make(chan struct{}),
+	}
+	w.current.Store(cert)
+
+	self.Certificate = cert
+	self.certGetter = w.getCertificate
+	self.certWatcher = w
+
+	go w.watch(interval)
+
+	return nil
+}
+
+// watchedCertificate holds the currently-loaded certificate plus the file
+// mtimes it was loaded from, and reloads it from disk when those change.
+type watchedCertificate struct {
+	certPath, keyPath       string
+	certModTime, keyModTime time.Time
+	current                 atomic.Value // *tls.Certificate
+	stop                    chan struct{}
+	stopOnce                sync.Once
+}
+
+func (w *watchedCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return w.current.Load().(*tls.Certificate), nil
+}
+
+func (w *watchedCertificate) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.reloadIfChanged()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// close stops the watch goroutine. It is safe to call more than once.
+func (w *watchedCertificate) close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *watchedCertificate) reloadIfChanged() {
+	certInfo, err := os.Stat(w.certPath)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(w.keyPath)
+	if err != nil {
+		return
+	}
+
+	if certInfo.ModTime().Equal(w.certModTime) && keyInfo.ModTime().Equal(w.keyModTime) {
+		return
+	}
+
+	cert, err := LoadCertificateFromFiles(w.certPath, w.keyPath)
+	if err != nil {
+		return
+	}
+
+	w.current.Store(cert)
+	w.certModTime = certInfo.ModTime()
+	w.keyModTime = keyInfo.ModTime()
+}
@@ -0,0 +1,99 @@
+package nanoauth
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MatchKind selects how an ExcludeRule's Pattern is interpreted.
+type MatchKind int
+
+const (
+	// MatchExact matches a request path that is identical to Pattern.
+	MatchExact MatchKind = iota
+
+	// MatchPrefix matches a request path that starts with Pattern, minus any
+	// trailing "*", e.g. "/static/*" excludes everything under /static/.
+	MatchPrefix
+
+	// MatchGlob matches using path.Match shell-style glob semantics.
+	MatchGlob
+
+	// MatchRegex matches using a compiled regular expression.
+	MatchRegex
+)
+
+// ExcludeRule describes a single path (and, optionally, HTTP method) that
+// should bypass authentication. Method, when non-empty, restricts the rule to
+// that HTTP method only (e.g. only exclude GET /health, not DELETE /health).
+type ExcludeRule struct {
+	Pattern string
+	Kind    MatchKind
+	Method  string
+}
+
+// compiledExcludeRule is an ExcludeRule with its regex, if any, precompiled
+// so ServeHTTP never pays compilation cost per request.
+type compiledExcludeRule struct {
+	rule   ExcludeRule
+	prefix string
+	regex  *regexp.Regexp
+}
+
+// compileExcludeRules precompiles rules once, at server start, rather than on
+// every request.
+func compileExcludeRules(rules []ExcludeRule) ([]compiledExcludeRule, error) {
+	compiled := make([]compiledExcludeRule, len(rules))
+
+	for i, rule := range rules {
+		c := compiledExcludeRule{rule: rule}
+
+		switch rule.Kind {
+		case MatchPrefix:
+			c.prefix = strings.TrimSuffix(rule.Pattern, "*")
+		case MatchRegex:
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			c.regex = re
+		}
+
+		compiled[i] = c
+	}
+
+	return compiled, nil
+}
+
+// excluded reports whether req should bypass authentication according to
+// rules.
+func excluded(rules []compiledExcludeRule, req *http.Request) bool {
+	for _, c := range rules {
+		if c.rule.Method != "" && c.rule.Method != req.Method {
+			continue
+		}
+
+		switch c.rule.Kind {
+		case MatchExact:
+			if c.rule.Pattern == req.URL.Path {
+				return true
+			}
+		case MatchPrefix:
+			if strings.HasPrefix(req.URL.Path, c.prefix) {
+				return true
+			}
+		case MatchGlob:
+			if ok, _ := path.Match(c.rule.Pattern, req.URL.Path); ok {
+				return true
+			}
+		case MatchRegex:
+			if c.regex.MatchString(req.URL.Path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package nanoauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExcludedMatchExact(t *testing.T) {
+	compiled, err := compileExcludeRules([]ExcludeRule{{Pattern: "/health", Kind: MatchExact}})
+	if err != nil {
+		t.Fatalf("compileExcludeRules: %v", err)
+	}
+
+	if !excluded(compiled, httptest.NewRequest(http.MethodGet, "/health", nil)) {
+		t.Error("expected exact match on /health to be excluded")
+	}
+	if excluded(compiled, httptest.NewRequest(http.MethodGet, "/health/live", nil)) {
+		t.Error("expected /health/live not to match the exact /health rule")
+	}
+}
+
+func TestExcludedMatchPrefix(t *testing.T) {
+	compiled, err := compileExcludeRules([]ExcludeRule{{Pattern: "/static/*", Kind: MatchPrefix}})
+	if err != nil {
+		t.Fatalf("compileExcludeRules: %v", err)
+	}
+
+	if !excluded(compiled, httptest.NewRequest(http.MethodGet, "/static/app.js", nil)) {
+		t.Error("expected /static/app.js to match the /static/* prefix rule")
+	}
+	if excluded(compiled, httptest.NewRequest(http.MethodGet, "/api/static", nil)) {
+		t.Error("expected /api/static not to match the /static/* prefix rule")
+	}
+}
+
+func TestExcludedMatchGlob(t *testing.T) {
+	compiled, err := compileExcludeRules([]ExcludeRule{{Pattern: "/users/*/avatar", Kind: MatchGlob}})
+	if err != nil {
+		t.Fatalf("compileExcludeRules: %v", err)
+	}
+
+	if !excluded(compiled, httptest.NewRequest(http.MethodGet, "/users/42/avatar", nil)) {
+		t.Error("expected /users/42/avatar to match the glob rule")
+	}
+	if excluded(compiled, httptest.NewRequest(http.MethodGet, "/users/42/profile", nil)) {
+		t.Error("expected /users/42/profile not to match the glob rule")
+	}
+}
+
+func TestExcludedMatchRegex(t *testing.T) {
+	compiled, err := compileExcludeRules([]ExcludeRule{{Pattern: `^/v[0-9]+/health$`, Kind: MatchRegex}})
+	if err != nil {
+		t.Fatalf("compileExcludeRules: %v", err)
+	}
+
+	if !excluded(compiled, httptest.NewRequest(http.MethodGet, "/v2/health", nil)) {
+		t.Error("expected /v2/health to match the regex rule")
+	}
+	if excluded(compiled, httptest.NewRequest(http.MethodGet, "/v2/healthcheck", nil)) {
+		t.Error("expected /v2/healthcheck not to match the anchored regex rule")
+	}
+}
+
+func TestExcludedInvalidRegexFailsToCompile(t *testing.T) {
+	if _, err := compileExcludeRules([]ExcludeRule{{Pattern: "(", Kind: MatchRegex}}); err == nil {
+		t.Fatal("expected an invalid regex pattern to fail to compile")
+	}
+}
+
+func TestExcludedRespectsMethod(t *testing.T) {
+	compiled, err := compileExcludeRules([]ExcludeRule{{Pattern: "/health", Kind: MatchExact, Method: http.MethodGet}})
+	if err != nil {
+		t.Fatalf("compileExcludeRules: %v", err)
+	}
+
+	if !excluded(compiled, httptest.NewRequest(http.MethodGet, "/health", nil)) {
+		t.Error("expected GET /health to be excluded")
+	}
+	if excluded(compiled, httptest.NewRequest(http.MethodDelete, "/health", nil)) {
+		t.Error("expected DELETE /health not to be excluded when the rule is scoped to GET")
+	}
+}
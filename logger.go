@@ -0,0 +1,64 @@
+package nanoauth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// LogEntry describes a single completed request, passed to Logger.Log.
+type LogEntry struct {
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Status     int           `json:"status"`
+	Latency    time.Duration `json:"latency"`
+	RemoteIP   string        `json:"remote_ip"`
+	AuthResult string        `json:"auth_result"` // "ok", "missing", "invalid", or "excluded"
+	Identity   string        `json:"identity,omitempty"`
+}
+
+// Logger records completed requests. Set Auth.Logger to turn today's opaque
+// 401s into something operable.
+type Logger interface {
+	Log(entry LogEntry)
+}
+
+// Metrics exports request/auth counters and latencies to a metrics backend.
+// See NewPrometheusMetrics, in a file built only with the "prometheus" build
+// tag, for a Prometheus-backed implementation - the core package stays free
+// of that dependency unless a caller opts in.
+type Metrics interface {
+	ObserveRequest(entry LogEntry)
+	ObserveAuthFailure(authResult string)
+}
+
+// JSONLogger is the default Logger: it writes each LogEntry as a line of
+// JSON to Writer (os.Stderr if Writer is nil).
+type JSONLogger struct {
+	Writer io.Writer
+}
+
+// Log implements Logger.
+func (l JSONLogger) Log(entry LogEntry) {
+	w := l.Writer
+	if w == nil {
+		w = os.Stderr
+	}
+
+	enc := json.NewEncoder(w)
+	enc.Encode(entry)
+}
+
+// statusWriter wraps an http.ResponseWriter to capture the status code
+// written, for logging/metrics purposes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,90 @@
+package nanoauth
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ListenAndServeMulti is like ListenAndServe but binds every address in
+// addrs (e.g. "127.0.0.1:8080" and a unix socket, or separate IPv4/IPv6
+// addresses) and serves all of them concurrently with the same handler and
+// token. It returns the first error from any listener, via an errgroup.
+func (self *Auth) ListenAndServeMulti(addrs []string, token string, h http.Handler, excludedPaths ...string) error {
+	listeners, err := listenAll(addrs, func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := self.prepare(token, h, excludedPaths); err != nil {
+		closeAll(listeners)
+		return err
+	}
+
+	self.server = self.newServer()
+
+	return serveAll(self.server, listeners)
+}
+
+// ListenAndServeMultiTLS is the TLS counterpart to ListenAndServeMulti.
+func (self *Auth) ListenAndServeMultiTLS(addrs []string, token string, h http.Handler, excludedPaths ...string) error {
+	if err := self.prepare(token, h, excludedPaths); err != nil {
+		return err
+	}
+
+	self.server = self.newServer()
+	if err := configureHTTP2(self.server); err != nil {
+		return err
+	}
+
+	listeners, err := listenAll(addrs, func(addr string) (net.Listener, error) {
+		return tls.Listen("tcp", addr, self.server.TLSConfig)
+	})
+	if err != nil {
+		return err
+	}
+
+	return serveAll(self.server, listeners)
+}
+
+// listenAll opens a listener for every addr with open, closing whatever was
+// already opened if one fails.
+func listenAll(addrs []string, open func(addr string) (net.Listener, error)) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+
+	for _, addr := range addrs {
+		l, err := open(addr)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// serveAll runs server.Serve on every listener concurrently, returning the
+// first error any of them produces.
+func serveAll(server *http.Server, listeners []net.Listener) error {
+	var g errgroup.Group
+
+	for _, l := range listeners {
+		l := l
+		g.Go(func() error {
+			return server.Serve(l)
+		})
+	}
+
+	return g.Wait()
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
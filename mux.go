@@ -0,0 +1,178 @@
+package nanoauth
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/soheilhy/cmux"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ListenAndServeMuxed opens a single TLS listener on addr and splits it, via
+// cmux, into gRPC traffic (HTTP/2 requests advertising the
+// "application/grpc" content-type) and everything else, which is served as
+// plain HTTP/JSON by httpHandler. This lets one authenticated port carry
+// both kinds of traffic instead of requiring separate listeners.
+//
+// grpcServer should already have self.UnaryServerInterceptor() and
+// self.StreamServerInterceptor() installed via grpc.NewServer's
+// ChainUnaryInterceptor/ChainStreamInterceptor options, since a grpc.Server's
+// interceptors can't be changed after construction. If grpcServer is nil,
+// only the HTTP side is served.
+//
+// self.Shutdown/self.Close stop grpcServer and the cmux listener alongside
+// the HTTP server, so either can be used for a graceful or immediate
+// shutdown of the whole muxed listener.
+func (self *Auth) ListenAndServeMuxed(addr, token string, httpHandler http.Handler, grpcServer *grpc.Server, excludedPaths ...string) error {
+	if err := self.prepare(token, httpHandler, excludedPaths); err != nil {
+		return err
+	}
+
+	self.server = self.newServer()
+	if err := configureHTTP2(self.server); err != nil {
+		return err
+	}
+
+	tlsListener, err := tls.Listen("tcp", addr, self.server.TLSConfig)
+	if err != nil {
+		return err
+	}
+
+	m := cmux.New(tlsListener)
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpListener := m.Match(cmux.Any())
+
+	self.muxListener = m
+	self.muxGRPCServer = grpcServer
+
+	// stopAll tears down all three listeners once any of them exits, so one
+	// returning (with or without an error) doesn't leave the other two
+	// running forever.
+	var stopOnce sync.Once
+	stopAll := func() {
+		stopOnce.Do(func() {
+			self.server.Close()
+			if grpcServer != nil {
+				grpcServer.Stop()
+			}
+			m.Close()
+		})
+	}
+
+	var g errgroup.Group
+
+	g.Go(func() error {
+		defer stopAll()
+		return self.server.Serve(httpListener)
+	})
+
+	if grpcServer != nil {
+		g.Go(func() error {
+			defer stopAll()
+			return grpcServer.Serve(grpcListener)
+		})
+	} else {
+		grpcListener.Close()
+	}
+
+	g.Go(func() error {
+		defer stopAll()
+		return m.Serve()
+	})
+
+	return g.Wait()
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that applies
+// self's authentication to unary RPCs: self.Authenticators if configured,
+// otherwise the Token/Tokens/TokenSource check, reading the token from
+// self.Header in the request's incoming metadata.
+func (self *Auth) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := self.authenticateGRPC(ctx); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming-RPC counterpart to
+// UnaryServerInterceptor.
+func (self *Auth) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := self.authenticateGRPC(ss.Context()); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// authenticateGRPC validates the credentials carried in ctx's incoming
+// metadata, using self.Authenticators if configured and falling back to the
+// legacy Token/Tokens/TokenSource check under self.Header otherwise - the
+// same precedence ServeHTTP applies to HTTP requests.
+func (self *Auth) authenticateGRPC(ctx context.Context) error {
+	if self.authDisabled {
+		return nil
+	}
+
+	if len(self.Authenticators) > 0 {
+		if _, ok := self.authenticate(requestFromGRPCContext(ctx)); !ok {
+			return status.Error(codes.Unauthenticated, "invalid or missing credentials")
+		}
+
+		return nil
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(strings.ToLower(self.Header)); len(values) > 0 {
+			token = values[0]
+		}
+	}
+
+	if !self.validAuth(token) {
+		return status.Error(codes.Unauthenticated, "invalid or missing token")
+	}
+
+	return nil
+}
+
+// requestFromGRPCContext adapts a gRPC ctx into the *http.Request shape
+// Authenticator.Authenticate expects, so self.Authenticators can be reused
+// unchanged for gRPC: incoming metadata becomes the request header (letting
+// HeaderTokenAuthenticator/BearerAuthenticator/BasicAuthenticator read it as
+// usual) and, for TLS connections, the peer's verified certificate chain
+// becomes r.TLS (for ClientCertAuthenticator).
+func requestFromGRPCContext(ctx context.Context) *http.Request {
+	header := make(http.Header)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, values := range md {
+			for _, v := range values {
+				header.Add(k, v)
+			}
+		}
+	}
+
+	req := &http.Request{Header: header, URL: &url.URL{}}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &tlsInfo.State
+		}
+	}
+
+	return req
+}
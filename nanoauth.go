@@ -5,27 +5,103 @@
 package nanoauth
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
+	"time"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
 )
 
 // Auth is a structure containing listener information
 type Auth struct {
-	child         http.Handler     // child is the http handler passed in
-	Header        string           // Header is the authentication token's header name
-	Certificate   *tls.Certificate // Certificate is the tls.Certificate to serve requests with
-	ExcludedPaths []string         // ExcludedPaths is a list of paths to be excluded from being authenticated
-	Token         string           // Token is the security/authentication string to validate by
-}
+	child         http.Handler                                         // child is the http handler passed in
+	server        *http.Server                                         // server is the http.Server owned by this Auth, set once serving starts
+	authDisabled  bool                                                 // authDisabled is set once, before serving, when no token is configured
+	compiledRules []compiledExcludeRule                                // compiledRules is ExcludeRules precompiled at server start
+	tokens        *tokenSet                                            // tokens is the live, rotatable set seeded from Token/Tokens
+	certGetter    func(*tls.ClientHelloInfo) (*tls.Certificate, error) // certGetter backs WatchCertificateFiles
+	certWatcher   *watchedCertificate                                  // certWatcher is stopped from Close/Shutdown, set by WatchCertificateFiles
+	muxListener   cmux.CMux                                            // muxListener is the cmux set up by ListenAndServeMuxed, stopped from Close/Shutdown
+	muxGRPCServer *grpc.Server                                         // muxGRPCServer is the gRPC server passed to ListenAndServeMuxed, stopped from Close/Shutdown
+	prepared      bool                                                 // prepared is set once prepare has run, so Serve/ServeTLS don't redo it
+	Header        string                                               // Header is the authentication token's header name
+	Certificate   *tls.Certificate                                     // Certificate is the tls.Certificate to serve requests with
+	ExcludeRules  []ExcludeRule                                        // ExcludeRules lists the requests to be excluded from being authenticated
+	Token         string                                               // Token is the security/authentication string to validate by
 
-var (
-	// DefaultAuth is the default Auth object
-	DefaultAuth = &Auth{}
+	// Tokens is an additional set of tokens accepted alongside Token. Use
+	// AddToken/RevokeToken to change the live set seeded from it once the
+	// server is already serving; they do not modify this field.
+	Tokens []string
 
-	// whether or not to check auth tokens
-	check = true
-)
+	// TokenSource, if set, is consulted on every request for the current set
+	// of valid tokens, letting callers hot-rotate credentials externally.
+	TokenSource TokenSource
+
+	// OnAuthFailure, if set, is called with the request and the token it
+	// presented whenever authentication fails, for audit logging.
+	OnAuthFailure func(req *http.Request, providedToken string)
+
+	// Authenticators, if non-empty, replaces the Header/Token/Tokens check
+	// above: each is tried in order and the first to succeed resolves the
+	// request's identity, which downstream handlers can read via Identity.
+	Authenticators []Authenticator
+
+	// TLSConfig, if set, seeds the tls.Config used by ListenAndServeTLS and
+	// ServeTLS - set MinVersion, CipherSuites, CurvePreferences, ClientAuth,
+	// or ClientCAs here to override the defaults. Certificates/GetCertificate
+	// are always populated from Certificate/WatchCertificateFiles.
+	TLSConfig *tls.Config
+
+	// RequireClientCert is a shortcut for TLSConfig.ClientAuth =
+	// tls.RequireAndVerifyClientCert.
+	RequireClientCert bool
+
+	limiter  *rateLimiter
+	inFlight *inFlightLimiter
+
+	// RateLimit configures the request-rate limit applied before dispatching
+	// to self.child. Zero RPS disables rate limiting.
+	RateLimit RateLimit
+
+	// TrustedProxies lists the IPs/CIDRs allowed to supply a client IP via
+	// X-Forwarded-For, for RateLimit.PerIP.
+	TrustedProxies []string
+
+	// MaxInFlight caps the number of concurrent requests presenting the same
+	// token; once reached, further requests with that token get a 429 until
+	// one finishes. Zero disables the cap.
+	MaxInFlight int
+
+	// Logger, if set, is called with a LogEntry once each request completes.
+	Logger Logger
+
+	// Metrics, if set, is called alongside Logger to export request/auth
+	// counters and latencies.
+	Metrics Metrics
+
+	// ReadTimeout is the maximum duration for reading the entire request,
+	// including the body. See http.Server.ReadTimeout.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read request headers.
+	// See http.Server.ReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response. See http.Server.WriteTimeout.
+	WriteTimeout time.Duration
+
+	// IdleTimeout is the maximum amount of time to wait for the next request
+	// when keep-alives are enabled. See http.Server.IdleTimeout.
+	IdleTimeout time.Duration
+}
+
+// DefaultAuth is the default Auth object
+var DefaultAuth = &Auth{}
 
 func init() {
 	DefaultAuth.Header = "X-NANOBOX-TOKEN"
@@ -35,54 +111,101 @@ func init() {
 // ServeHTTP is to implement the http.Handler interface. Also let clients know
 // when I have no matching route listeners
 func (self Auth) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	reqPath := req.URL.Path
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+	authResult, identity := "ok", ""
 
-	for _, path := range self.ExcludedPaths {
-		if path == reqPath {
-			check = false
-			break
-		}
+	if self.Logger != nil || self.Metrics != nil {
+		defer func() {
+			entry := LogEntry{
+				Method:     req.Method,
+				Path:       req.URL.Path,
+				Status:     sw.status,
+				Latency:    time.Since(start),
+				RemoteIP:   self.clientIP(req),
+				AuthResult: authResult,
+				Identity:   identity,
+			}
+			if self.Logger != nil {
+				self.Logger.Log(entry)
+			}
+			if self.Metrics != nil {
+				self.Metrics.ObserveRequest(entry)
+				if authResult == "missing" || authResult == "invalid" {
+					self.Metrics.ObserveAuthFailure(authResult)
+				}
+			}
+		}()
 	}
 
-	if check {
-		auth := ""
+	if !self.limiter.allow(self.clientIP(req)) {
+		sw.Header().Set("Retry-After", "1")
+		sw.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	var auth string
+	switch {
+	case self.authDisabled:
+		// authResult stays "ok"
+	case excluded(self.compiledRules, req):
+		authResult = "excluded"
+	case len(self.Authenticators) > 0:
+		id, ok := self.authenticate(req)
+		if !ok {
+			authResult = "invalid"
+			self.reportAuthFailure(req, "")
+			sw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		identity = id
+		req = req.WithContext(context.WithValue(req.Context(), identityContextKey{}, identity))
+	default:
 		if auth = req.Header.Get(self.Header); auth == "" {
 			// check form value (case sensitive) if header not set
 			auth = req.FormValue(self.Header)
 		}
 
-		if auth != self.Token {
-			rw.WriteHeader(http.StatusUnauthorized)
+		if !self.validAuth(auth) {
+			if auth == "" {
+				authResult = "missing"
+			} else {
+				authResult = "invalid"
+			}
+			self.reportAuthFailure(req, auth)
+			sw.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 	}
 
-	self.child.ServeHTTP(rw, req)
+	key := self.inFlightKey(req, identity, auth)
+	if !self.inFlight.acquire(key) {
+		sw.Header().Set("Retry-After", "1")
+		sw.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	defer self.inFlight.release(key)
+
+	self.child.ServeHTTP(sw, req)
 }
 
 // ListenAndServeTLS starts a TLS listener and handles serving https
 func (self *Auth) ListenAndServeTLS(addr, token string, h http.Handler, excludedPaths ...string) error {
-	config := &tls.Config{
-		Certificates: []tls.Certificate{*self.Certificate},
-	}
-	config.BuildNameToCertificate()
-	tlsListener, err := tls.Listen("tcp", addr, config)
-	if err != nil {
+	if err := self.prepare(token, h, excludedPaths); err != nil {
 		return err
 	}
 
-	if token == "" {
-		check = false
+	self.server = self.newServer()
+	if err := configureHTTP2(self.server); err != nil {
+		return err
 	}
-	self.ExcludedPaths = excludedPaths
-	self.Token = token
 
-	if h == nil {
-		h = http.DefaultServeMux
+	tlsListener, err := tls.Listen("tcp", addr, self.server.TLSConfig)
+	if err != nil {
+		return err
 	}
-	self.child = h
 
-	return http.Serve(tlsListener, self)
+	return self.server.Serve(tlsListener)
 }
 
 // ListenAndServe starts a normal tcp listener and handles serving http while
@@ -93,18 +216,170 @@ func (self *Auth) ListenAndServe(addr, token string, h http.Handler, excludedPat
 		return err
 	}
 
-	if token == "" {
-		check = false
+	if err := self.prepare(token, h, excludedPaths); err != nil {
+		return err
 	}
-	self.ExcludedPaths = excludedPaths
+
+	return self.Serve(httpListener)
+}
+
+// Serve accepts incoming connections on l, handing each to self until
+// Shutdown or Close is called, at which point Serve returns
+// http.ErrServerClosed. It lets callers that already have a net.Listener
+// (e.g. from systemd socket activation) hand it in directly instead of going
+// through ListenAndServe. Token, Tokens, Authenticators, ExcludeRules and the
+// rest of self's fields must be set directly on the struct beforehand, since
+// there is no token/handler argument to pass in as there is with
+// ListenAndServe. The handler has no exported field: unless self was already
+// prepared by an earlier ListenAndServe-style call, Serve dispatches to
+// http.DefaultServeMux.
+func (self *Auth) Serve(l net.Listener) error {
+	if err := self.ensurePrepared(); err != nil {
+		return err
+	}
+
+	self.server = self.newServer()
+
+	return self.server.Serve(l)
+}
+
+// ServeTLS is the TLS counterpart to Serve for callers that already have a
+// net.Listener and want self to take care of the TLS handshake using
+// self.Certificate. As with Serve, self's fields must already be set since
+// there is no token/handler argument to pass in.
+func (self *Auth) ServeTLS(l net.Listener) error {
+	if err := self.ensurePrepared(); err != nil {
+		return err
+	}
+
+	self.server = self.newServer()
+	if err := configureHTTP2(self.server); err != nil {
+		return err
+	}
+
+	return self.server.ServeTLS(l, "", "")
+}
+
+// Shutdown gracefully shuts down the server without interrupting any active
+// connections, the same way http.Server.Shutdown does. It returns ctx.Err()
+// if ctx expires before shutdown completes. Shutdown is a no-op if the server
+// has not started serving yet. If self was started via ListenAndServeMuxed,
+// it also gracefully stops the gRPC server and the cmux listener; if self was
+// started via WatchCertificateFiles, it also stops the background reload
+// goroutine.
+func (self *Auth) Shutdown(ctx context.Context) error {
+	if self.server == nil {
+		return nil
+	}
+
+	if self.certWatcher != nil {
+		self.certWatcher.close()
+	}
+
+	if self.muxGRPCServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			self.muxGRPCServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			self.muxGRPCServer.Stop()
+		}
+	}
+	if self.muxListener != nil {
+		self.muxListener.Close()
+	}
+
+	return self.server.Shutdown(ctx)
+}
+
+// Close immediately closes the listener and any active connections. Prefer
+// Shutdown for a graceful stop. Close is a no-op if the server has not
+// started serving yet. If self was started via ListenAndServeMuxed, it also
+// stops the gRPC server and the cmux listener; if self was started via
+// WatchCertificateFiles, it also stops the background reload goroutine.
+func (self *Auth) Close() error {
+	if self.server == nil {
+		return nil
+	}
+
+	if self.certWatcher != nil {
+		self.certWatcher.close()
+	}
+
+	if self.muxGRPCServer != nil {
+		self.muxGRPCServer.Stop()
+	}
+	if self.muxListener != nil {
+		self.muxListener.Close()
+	}
+
+	return self.server.Close()
+}
+
+// prepare sets the fields shared by ListenAndServe and ListenAndServeTLS
+// before serving begins, and precompiles the exclude rules so ServeHTTP
+// never has to do it per-request.
+func (self *Auth) prepare(token string, h http.Handler, excludedPaths []string) error {
+	self.authDisabled = token == "" && len(self.Tokens) == 0 && self.TokenSource == nil && len(self.Authenticators) == 0
 	self.Token = token
 
+	self.limiter = newRateLimiter(self.RateLimit)
+	self.inFlight = newInFlightLimiter(self.MaxInFlight)
+
+	seed := make([]string, 0, len(self.Tokens)+1)
+	if token != "" {
+		seed = append(seed, token)
+	}
+	seed = append(seed, self.Tokens...)
+	self.tokens = newTokenSet(seed)
+
+	for _, p := range excludedPaths {
+		self.ExcludeRules = append(self.ExcludeRules, ExcludeRule{Pattern: p, Kind: MatchExact})
+	}
+
+	compiled, err := compileExcludeRules(self.ExcludeRules)
+	if err != nil {
+		return err
+	}
+	self.compiledRules = compiled
+
 	if h == nil {
 		h = http.DefaultServeMux
 	}
 	self.child = h
 
-	return http.Serve(httpListener, self)
+	self.prepared = true
+
+	return nil
+}
+
+// ensurePrepared runs prepare off of whatever Token/Tokens/Authenticators/
+// ExcludeRules/child the caller has already set directly on self, for Serve
+// and ServeTLS callers who bypass ListenAndServe/ListenAndServeTLS (and so
+// never pass a token/handler/excludedPaths to prepare themselves). It is a
+// no-op once self has already been prepared.
+func (self *Auth) ensurePrepared() error {
+	if self.prepared {
+		return nil
+	}
+
+	return self.prepare(self.Token, self.child, nil)
+}
+
+// newServer builds the *http.Server used to serve self, applying whichever
+// timeouts have been configured on self.
+func (self *Auth) newServer() *http.Server {
+	return &http.Server{
+		Handler:           self,
+		TLSConfig:         self.tlsConfig(),
+		ReadTimeout:       self.ReadTimeout,
+		ReadHeaderTimeout: self.ReadHeaderTimeout,
+		WriteTimeout:      self.WriteTimeout,
+		IdleTimeout:       self.IdleTimeout,
+	}
 }
 
 // ListenAndServeTLS is a shortcut function which uses the default one
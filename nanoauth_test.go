@@ -0,0 +1,51 @@
+package nanoauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestServeHTTPConcurrentRequestsDoNotRaceAuthState guards against the bug
+// fixed in chunk0-2: auth state used to live in a package-level variable
+// shared by every Auth instance, so concurrent requests could corrupt each
+// other's view of whether auth was enabled and permanently disable auth for
+// the whole process. Run with -race to catch a regression.
+func TestServeHTTPConcurrentRequestsDoNotRaceAuthState(t *testing.T) {
+	auth := &Auth{
+		Header: "X-Token",
+		Token:  "secret",
+		tokens: newTokenSet([]string{"secret"}),
+		child:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		valid := i%2 == 0
+
+		wg.Add(1)
+		go func(valid bool) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if valid {
+				req.Header.Set(auth.Header, "secret")
+			} else {
+				req.Header.Set(auth.Header, "wrong")
+			}
+
+			rec := httptest.NewRecorder()
+			auth.ServeHTTP(rec, req)
+
+			want := http.StatusUnauthorized
+			if valid {
+				want = http.StatusOK
+			}
+			if rec.Code != want {
+				t.Errorf("valid=%v: got status %d, want %d", valid, rec.Code, want)
+			}
+		}(valid)
+	}
+	wg.Wait()
+}
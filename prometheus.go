@@ -0,0 +1,57 @@
+//go:build prometheus
+
+package nanoauth
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics implements Metrics using a prometheus.Registerer,
+// exporting nanoauth_requests_total{status,auth_result},
+// nanoauth_request_duration_seconds, and nanoauth_auth_failures_total. It's
+// only compiled in when building with -tags prometheus, so the core package
+// doesn't pull in the Prometheus client unless a caller opts in.
+type PrometheusMetrics struct {
+	requestsTotal     *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	authFailuresTotal *prometheus.CounterVec
+}
+
+// NewPrometheusMetrics registers its collectors with reg and returns a
+// Metrics implementation ready to assign to Auth.Metrics.
+func NewPrometheusMetrics(reg prometheus.Registerer) *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nanoauth_requests_total",
+			Help: "Total requests handled, labeled by status and auth result.",
+		}, []string{"status", "auth_result"}),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "nanoauth_request_duration_seconds",
+			Help: "Request latency in seconds.",
+		}, []string{"status"}),
+
+		authFailuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nanoauth_auth_failures_total",
+			Help: "Total authentication failures, labeled by auth result.",
+		}, []string{"auth_result"}),
+	}
+
+	reg.MustRegister(m.requestsTotal, m.requestDuration, m.authFailuresTotal)
+
+	return m
+}
+
+// ObserveRequest implements Metrics.
+func (m *PrometheusMetrics) ObserveRequest(entry LogEntry) {
+	status := strconv.Itoa(entry.Status)
+	m.requestsTotal.WithLabelValues(status, entry.AuthResult).Inc()
+	m.requestDuration.WithLabelValues(status).Observe(entry.Latency.Seconds())
+}
+
+// ObserveAuthFailure implements Metrics.
+func (m *PrometheusMetrics) ObserveAuthFailure(authResult string) {
+	m.authFailuresTotal.WithLabelValues(authResult).Inc()
+}
@@ -0,0 +1,255 @@
+package nanoauth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// perIPIdleTTL is how long a per-IP limiter can sit unused before it's
+	// evicted from rateLimiter.byIP.
+	perIPIdleTTL = 10 * time.Minute
+
+	// perIPSweepEvery bounds how often limiterFor scans byIP for idle
+	// entries, so the scan doesn't run on every single request.
+	perIPSweepEvery = time.Minute
+)
+
+// RateLimit configures the token-bucket rate limiter applied to incoming
+// requests before they reach self.child.
+type RateLimit struct {
+	// RPS is the sustained requests-per-second allowed. Zero disables rate
+	// limiting entirely.
+	RPS float64
+
+	// Burst is the number of requests allowed to exceed RPS briefly.
+	Burst int
+
+	// PerIP, if true, additionally enforces RPS/Burst separately for each
+	// client IP (resolved via TrustedProxies), on top of the global limit.
+	PerIP bool
+}
+
+// ipLimiter is a per-IP rate.Limiter plus the last time it was used, so
+// rateLimiter can evict entries nobody's hit in a while.
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter is the live limiter state built from RateLimit. It's
+// referenced from Auth by pointer so Auth, which is copied per request in
+// ServeHTTP, stays cheap to copy.
+type rateLimiter struct {
+	global *rate.Limiter
+
+	perIP bool
+	rps   rate.Limit
+	burst int
+
+	// idleTTL and sweepEvery default to perIPIdleTTL/perIPSweepEvery; tests
+	// override them to exercise eviction without waiting out the real TTL.
+	idleTTL    time.Duration
+	sweepEvery time.Duration
+
+	mu        sync.Mutex
+	byIP      map[string]*ipLimiter
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	if cfg.RPS <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		global:     rate.NewLimiter(rate.Limit(cfg.RPS), cfg.Burst),
+		perIP:      cfg.PerIP,
+		rps:        rate.Limit(cfg.RPS),
+		burst:      cfg.Burst,
+		idleTTL:    perIPIdleTTL,
+		sweepEvery: perIPSweepEvery,
+		byIP:       make(map[string]*ipLimiter),
+	}
+}
+
+func (r *rateLimiter) allow(ip string) bool {
+	if r == nil {
+		return true
+	}
+
+	if !r.global.Allow() {
+		return false
+	}
+
+	if !r.perIP {
+		return true
+	}
+
+	return r.limiterFor(ip).Allow()
+}
+
+func (r *rateLimiter) limiterFor(ip string) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	entry, ok := r.byIP[ip]
+	if !ok {
+		entry = &ipLimiter{limiter: rate.NewLimiter(r.rps, r.burst)}
+		r.byIP[ip] = entry
+	}
+	entry.lastSeen = now
+
+	r.sweepLocked(now)
+
+	return entry.limiter
+}
+
+// sweepLocked evicts byIP entries idle for longer than r.idleTTL, at most
+// once every r.sweepEvery, so an attacker spraying distinct source IPs (or
+// spoofed X-Forwarded-For values through a trusted proxy) can't grow byIP
+// without bound. Callers must hold r.mu.
+func (r *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(r.lastSweep) < r.sweepEvery {
+		return
+	}
+	r.lastSweep = now
+
+	for ip, entry := range r.byIP {
+		if now.Sub(entry.lastSeen) > r.idleTTL {
+			delete(r.byIP, ip)
+		}
+	}
+}
+
+// inFlightLimiter caps the number of concurrent requests presenting the same
+// token, per Auth.MaxInFlight.
+type inFlightLimiter struct {
+	max int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newInFlightLimiter(max int) *inFlightLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &inFlightLimiter{max: max, counts: make(map[string]int)}
+}
+
+func (l *inFlightLimiter) acquire(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[key] >= l.max {
+		return false
+	}
+	l.counts[key]++
+
+	return true
+}
+
+func (l *inFlightLimiter) release(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counts[key]--
+	if l.counts[key] <= 0 {
+		delete(l.counts, key)
+	}
+}
+
+// inFlightKey picks the key self.inFlight caps concurrency by, so
+// MaxInFlight limits each caller rather than becoming one global cap shared
+// by everyone. identity is whatever self.Authenticators resolved (empty if
+// that path wasn't taken or didn't succeed) and auth is the legacy
+// Header/form token validated by the default branch of ServeHTTP (empty if
+// Authenticators are configured instead, or validation never ran). When
+// neither identifies the caller and self.Authenticators is configured (the
+// request was excluded), this falls back to the client IP so unauthenticated
+// callers still get capped individually instead of sharing a single bucket
+// keyed by "". Otherwise (auth disabled, or an excluded request under the
+// legacy check) it uses the token the request presents, unvalidated, so
+// such requests carrying no token share the "" bucket.
+func (self Auth) inFlightKey(req *http.Request, identity, auth string) string {
+	if identity != "" {
+		return identity
+	}
+	if auth != "" {
+		return auth
+	}
+	if len(self.Authenticators) > 0 {
+		return self.clientIP(req)
+	}
+
+	return self.requestToken(req)
+}
+
+// requestToken extracts the caller-presented token for in-flight
+// bookkeeping, without validating it.
+func (self Auth) requestToken(req *http.Request) string {
+	if token := req.Header.Get(self.Header); token != "" {
+		return token
+	}
+
+	return req.FormValue(self.Header)
+}
+
+// clientIP resolves req's client IP, trusting the X-Forwarded-For header
+// only when the immediate peer's address is in self.TrustedProxies.
+func (self Auth) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	if !self.isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := req.Header.Get("X-Forwarded-For")
+	if forwarded == "" {
+		return host
+	}
+
+	if i := strings.IndexByte(forwarded, ','); i >= 0 {
+		forwarded = forwarded[:i]
+	}
+
+	return strings.TrimSpace(forwarded)
+}
+
+func (self Auth) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range self.TrustedProxies {
+		if proxy == host {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(proxy); err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package nanoauth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterGlobalLimit(t *testing.T) {
+	r := newRateLimiter(RateLimit{RPS: 1, Burst: 1})
+
+	if !r.allow("1.2.3.4") {
+		t.Fatal("first request should be allowed under burst 1")
+	}
+	if r.allow("1.2.3.4") {
+		t.Fatal("second immediate request should be throttled by the global limit")
+	}
+}
+
+func TestRateLimiterPerIPIsIndependentOfGlobal(t *testing.T) {
+	r := newRateLimiter(RateLimit{RPS: 100, Burst: 100, PerIP: true})
+
+	for i := 0; i < 3; i++ {
+		if !r.allow("10.0.0.1") {
+			t.Fatalf("request %d from 10.0.0.1 should be allowed", i)
+		}
+	}
+
+	// A different IP gets its own bucket, not sharing 10.0.0.1's usage.
+	if !r.allow("10.0.0.2") {
+		t.Fatal("a different IP should have its own per-IP bucket")
+	}
+}
+
+func TestRateLimiterPerIPThrottlesExhaustedIP(t *testing.T) {
+	r := newRateLimiter(RateLimit{RPS: 1, Burst: 1, PerIP: true})
+
+	if !r.allow("10.0.0.1") {
+		t.Fatal("first request from 10.0.0.1 should be allowed")
+	}
+	if r.allow("10.0.0.1") {
+		t.Fatal("second immediate request from the same IP should be throttled")
+	}
+}
+
+func TestRateLimiterEvictsIdlePerIPEntries(t *testing.T) {
+	r := newRateLimiter(RateLimit{RPS: 100, Burst: 100, PerIP: true})
+	r.idleTTL = time.Millisecond
+	r.sweepEvery = 0
+
+	r.allow("10.0.0.1")
+	if _, ok := r.byIP["10.0.0.1"]; !ok {
+		t.Fatal("expected an entry for 10.0.0.1 right after it's seen")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	// Touching a different IP triggers the sweep and should evict the first,
+	// now-idle entry instead of letting byIP grow without bound.
+	r.allow("10.0.0.2")
+	if _, ok := r.byIP["10.0.0.1"]; ok {
+		t.Error("expected the idle 10.0.0.1 entry to be evicted")
+	}
+	if _, ok := r.byIP["10.0.0.2"]; !ok {
+		t.Error("expected the just-seen 10.0.0.2 entry to remain")
+	}
+}
+
+func TestInFlightLimiterCapsConcurrencyPerKey(t *testing.T) {
+	l := newInFlightLimiter(2)
+
+	if !l.acquire("alice") {
+		t.Fatal("1st concurrent request for alice should be allowed")
+	}
+	if !l.acquire("alice") {
+		t.Fatal("2nd concurrent request for alice should be allowed")
+	}
+	if l.acquire("alice") {
+		t.Fatal("3rd concurrent request for alice should be rejected past MaxInFlight")
+	}
+
+	// A different key has its own, independent budget.
+	if !l.acquire("bob") {
+		t.Fatal("bob should have an independent in-flight budget from alice")
+	}
+
+	l.release("alice")
+	if !l.acquire("alice") {
+		t.Fatal("releasing a slot should allow another request for the same key")
+	}
+}
+
+func TestInFlightLimiterDisabledWhenMaxIsZero(t *testing.T) {
+	l := newInFlightLimiter(0)
+	if l != nil {
+		t.Fatal("newInFlightLimiter(0) should disable the limiter (nil)")
+	}
+
+	for i := 0; i < 100; i++ {
+		if !l.acquire("anyone") {
+			t.Fatal("a nil limiter should always allow")
+		}
+	}
+}
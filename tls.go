@@ -0,0 +1,57 @@
+package nanoauth
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// defaultCipherSuites are used whenever TLSConfig doesn't specify its own,
+// restricted to suites that support forward secrecy.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// tlsConfig builds the tls.Config used to serve self: it starts from
+// self.TLSConfig, if set, fills in modern defaults for MinVersion and
+// CipherSuites when the caller left them unset, and populates the
+// certificate from Certificate or WatchCertificateFiles when either is set.
+func (self *Auth) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if self.TLSConfig != nil {
+		config = self.TLSConfig.Clone()
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if len(config.CipherSuites) == 0 {
+		config.CipherSuites = defaultCipherSuites
+	}
+
+	if self.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	switch {
+	case self.certGetter != nil:
+		config.GetCertificate = self.certGetter
+	case self.Certificate != nil:
+		config.Certificates = []tls.Certificate{*self.Certificate}
+	}
+
+	return config
+}
+
+// configureHTTP2 enables HTTP/2 (via ALPN) on server, adding "h2" to
+// TLSConfig.NextProtos alongside "http/1.1" and registering the HTTP/2
+// protocol handler.
+func configureHTTP2(server *http.Server) error {
+	return http2.ConfigureServer(server, &http2.Server{})
+}
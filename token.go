@@ -0,0 +1,115 @@
+package nanoauth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+)
+
+// TokenSource returns the currently-valid set of tokens. It lets operators
+// plug in their own source (a config file watch, a secrets manager poll,
+// etc.) so tokens can be rotated without restarting the server.
+type TokenSource interface {
+	Tokens() []string
+}
+
+// tokenSet is a mutable, concurrency-safe collection of valid tokens. It is
+// referenced from Auth by pointer so that Auth itself stays cheap to copy
+// (ServeHTTP takes a value receiver) while AddToken/RevokeToken can still
+// mutate the underlying set safely at runtime.
+type tokenSet struct {
+	mu     sync.RWMutex
+	tokens []string
+}
+
+func newTokenSet(tokens []string) *tokenSet {
+	return &tokenSet{tokens: tokens}
+}
+
+// valid reports whether token matches any token currently in the set, using
+// a constant-time comparison to avoid leaking timing information.
+func (t *tokenSet) valid(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, candidate := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *tokenSet) add(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, candidate := range t.tokens {
+		if candidate == token {
+			return
+		}
+	}
+	t.tokens = append(t.tokens, token)
+}
+
+func (t *tokenSet) revoke(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, candidate := range t.tokens {
+		if candidate == token {
+			t.tokens = append(t.tokens[:i], t.tokens[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddToken adds token to the set of currently-valid tokens, taking effect
+// immediately for subsequent requests. It is safe to call while the server
+// is serving requests. A call made before serving starts does not last:
+// serving starts by reseeding the set from Token and Tokens only.
+func (self *Auth) AddToken(token string) {
+	if self.tokens == nil {
+		self.tokens = newTokenSet(nil)
+	}
+	self.tokens.add(token)
+}
+
+// RevokeToken removes token from the set of currently-valid tokens, taking
+// effect immediately for subsequent requests. It is safe to call while the
+// server is serving requests. As with AddToken, a call made before serving
+// starts is undone when the set is reseeded from Token and Tokens.
+func (self *Auth) RevokeToken(token string) {
+	if self.tokens == nil {
+		self.tokens = newTokenSet(nil)
+	}
+	self.tokens.revoke(token)
+}
+
+// validAuth reports whether provided is one of self's currently-valid
+// tokens: the set built from Token/Tokens at server start (and since
+// mutated via AddToken/RevokeToken), plus whatever TokenSource currently
+// reports. All comparisons are constant-time.
+func (self Auth) validAuth(provided string) bool {
+	if self.tokens != nil && self.tokens.valid(provided) {
+		return true
+	}
+
+	if self.TokenSource != nil {
+		for _, candidate := range self.TokenSource.Tokens() {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(candidate)) == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// reportAuthFailure invokes OnAuthFailure, if set, for audit logging.
+func (self Auth) reportAuthFailure(req *http.Request, provided string) {
+	if self.OnAuthFailure != nil {
+		self.OnAuthFailure(req, provided)
+	}
+}
@@ -0,0 +1,66 @@
+package nanoauth
+
+import "testing"
+
+func TestTokenSetValid(t *testing.T) {
+	set := newTokenSet([]string{"alpha", "beta"})
+
+	cases := []struct {
+		token string
+		want  bool
+	}{
+		{"alpha", true},
+		{"beta", true},
+		{"gamma", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := set.valid(c.token); got != c.want {
+			t.Errorf("valid(%q) = %v, want %v", c.token, got, c.want)
+		}
+	}
+}
+
+func TestTokenSetAddRevoke(t *testing.T) {
+	set := newTokenSet([]string{"alpha"})
+
+	if set.valid("beta") {
+		t.Fatal("beta should not be valid before add")
+	}
+
+	set.add("beta")
+	if !set.valid("beta") {
+		t.Fatal("beta should be valid after add")
+	}
+
+	set.revoke("alpha")
+	if set.valid("alpha") {
+		t.Fatal("alpha should be invalid after revoke")
+	}
+	if !set.valid("beta") {
+		t.Fatal("beta should still be valid after revoking alpha")
+	}
+}
+
+// tokenSourceFunc adapts a func to a TokenSource for tests.
+type tokenSourceFunc func() []string
+
+func (f tokenSourceFunc) Tokens() []string { return f() }
+
+func TestValidAuthChecksTokenSourceToo(t *testing.T) {
+	auth := Auth{
+		tokens:      newTokenSet([]string{"alpha"}),
+		TokenSource: tokenSourceFunc(func() []string { return []string{"rotated"} }),
+	}
+
+	if !auth.validAuth("alpha") {
+		t.Error("expected the statically-seeded token to remain valid")
+	}
+	if !auth.validAuth("rotated") {
+		t.Error("expected a token reported by TokenSource to be valid")
+	}
+	if auth.validAuth("unknown") {
+		t.Error("expected an unknown token to be rejected")
+	}
+}